@@ -3,19 +3,24 @@ package dns
 // A structure for handling zone data
 
 import (
-	"github.com/miekg/radix"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
-// Zone represents a DNS zone. It's safe for concurrent use by 
+// Zone represents a DNS zone. It's safe for concurrent use by
 // multilpe goroutines.
 type Zone struct {
-	Origin       string // Origin of the zone
-	Wildcard     int    // Whenever we see a wildcard name, this is incremented
-	*radix.Radix        // Zone data
-	mutex        *sync.RWMutex
+	Origin      string               // Origin of the zone
+	Wildcard    int                  // Whenever we see a wildcard name, this is incremented
+	names       map[string]*ZoneData // Zone data, keyed by its reversed, lowercased name
+	sorted      []string             // names' keys, in sorted order once sortedDirty is false
+	sortedDirty bool                 // true when keys have been appended to sorted since it was last sorted
+	mutex       *sync.RWMutex
 }
 
 // SignatureConfig holds the parameters for zone (re)signing. This 
@@ -56,7 +61,7 @@ func NewZone(origin string) *Zone {
 	z := new(Zone)
 	z.mutex = new(sync.RWMutex)
 	z.Origin = Fqdn(origin)
-	z.Radix = radix.New()
+	z.names = make(map[string]*ZoneData)
 	return z
 }
 
@@ -96,20 +101,28 @@ func toRadixName(d string) string {
 // Insert inserts an RR into the zone. There is no check for duplicate data, although
 // Remove will remove all duplicates.
 func (z *Zone) Insert(r RR) error {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	return z.insertLocked(r)
+}
+
+// insertLocked is Insert's core logic. z.mutex must already be held by
+// the caller; this lets callers that must apply several RRs as a single
+// atomic unit (such as IXFR's delta application) do so under one hold
+// of z.mutex instead of one per RR.
+func (z *Zone) insertLocked(r RR) error {
 	if !IsSubDomain(z.Origin, r.Header().Name) {
 		return &Error{Err: "out of zone data", Name: r.Header().Name}
 	}
 
 	key := toRadixName(r.Header().Name)
-	z.mutex.Lock()
-	zd := z.Radix.Find(key)
-	if zd == nil {
-		defer z.mutex.Unlock()
+	zd, ok := z.names[key]
+	if !ok {
 		// Check if its a wildcard name
 		if len(r.Header().Name) > 1 && r.Header().Name[0] == '*' && r.Header().Name[1] == '.' {
 			z.Wildcard++
 		}
-		zd := newZoneData(r.Header().Name)
+		zd = newZoneData(r.Header().Name)
 		switch t := r.Header().Rrtype; t {
 		case TypeRRSIG:
 			sigtype := r.(*RR_RRSIG).TypeCovered
@@ -123,55 +136,102 @@ func (z *Zone) Insert(r RR) error {
 		default:
 			zd.RR[t] = append(zd.RR[t], r)
 		}
-		z.Radix.Insert(key, zd)
+		z.names[key] = zd
+		z.insertSorted(key)
 		return nil
 	}
-	z.mutex.Unlock()
-	zd.Value.(*ZoneData).mutex.Lock()
-	defer zd.Value.(*ZoneData).mutex.Unlock()
+	zd.mutex.Lock()
+	defer zd.mutex.Unlock()
 	// Name already there
 	switch t := r.Header().Rrtype; t {
 	case TypeRRSIG:
 		sigtype := r.(*RR_RRSIG).TypeCovered
-		zd.Value.(*ZoneData).Signatures[sigtype] = append(zd.Value.(*ZoneData).Signatures[sigtype], r.(*RR_RRSIG))
+		zd.Signatures[sigtype] = append(zd.Signatures[sigtype], r.(*RR_RRSIG))
 	case TypeNS:
 		if r.Header().Name != z.Origin {
-			zd.Value.(*ZoneData).NonAuth = true
+			zd.NonAuth = true
 		}
 		fallthrough
 	default:
-		zd.Value.(*ZoneData).RR[t] = append(zd.Value.(*ZoneData).RR[t], r)
+		zd.RR[t] = append(zd.RR[t], r)
 	}
 	return nil
 }
 
+// underDelegation reports whether name falls underneath a delegation
+// point: a proper ancestor of name, other than z.Origin, that already
+// holds a non-authoritative (NS) RRset. z.mutex must be held by the
+// caller.
+func (z *Zone) underDelegation(name string) bool {
+	for ancestor := name; ancestor != z.Origin && ancestor != "."; {
+		i := strings.Index(ancestor, ".")
+		if i < 0 {
+			return false
+		}
+		ancestor = ancestor[i+1:]
+		if ancestor == name {
+			return false
+		}
+		if zd, ok := z.names[toRadixName(ancestor)]; ok && zd.NonAuth {
+			return true
+		}
+	}
+	return false
+}
+
+// insertSorted records a newly inserted key. Rather than shifting
+// z.sorted on every Insert (an O(n) operation that would make loading
+// an N-record zone O(n^2)), it simply appends and marks z.sorted dirty;
+// the slice is sorted once, lazily, the next time ensureSorted is
+// called by something that needs the ordering (Predecessor, walk).
+// z.mutex must be held by the caller.
+func (z *Zone) insertSorted(key string) {
+	z.sorted = append(z.sorted, key)
+	z.sortedDirty = true
+}
+
+// ensureSorted sorts z.sorted if keys have been appended since it was
+// last sorted. z.mutex must be held (for writing, as this may mutate
+// z.sorted) by the caller.
+func (z *Zone) ensureSorted() {
+	if z.sortedDirty {
+		sort.Strings(z.sorted)
+		z.sortedDirty = false
+	}
+}
+
 // Remove removes the RR r from the zone. If the RR can not be found,
 // this is a no-op.
 func (z *Zone) Remove(r RR) error {
-	key := toRadixName(r.Header().Name)
 	z.mutex.Lock()
-	zd := z.Radix.Find(key)
-	if zd == nil {
-		defer z.mutex.Unlock()
+	defer z.mutex.Unlock()
+	return z.removeLocked(r)
+}
+
+// removeLocked is Remove's core logic. z.mutex must already be held by
+// the caller; see insertLocked.
+func (z *Zone) removeLocked(r RR) error {
+	key := toRadixName(r.Header().Name)
+	zd, ok := z.names[key]
+	if !ok {
 		return nil
 	}
-	z.mutex.Unlock()
-	zd.Value.(*ZoneData).mutex.Lock()
-	defer zd.Value.(*ZoneData).mutex.Unlock()
+	zd.mutex.Lock()
+	defer zd.mutex.Unlock()
 	remove := false
 	switch t := r.Header().Rrtype; t {
 	case TypeRRSIG:
 		sigtype := r.(*RR_RRSIG).TypeCovered
-		for i, zr := range zd.Value.(*ZoneData).RR[sigtype] {
+		for i, zr := range zd.RR[sigtype] {
 			if r == zr {
-				zd.Value.(*ZoneData).RR[sigtype] = append(zd.Value.(*ZoneData).RR[sigtype][:i], zd.Value.(*ZoneData).RR[sigtype][i+1:]...)
+				zd.RR[sigtype] = append(zd.RR[sigtype][:i], zd.RR[sigtype][i+1:]...)
 				remove = true
 			}
 		}
 	default:
-		for i, zr := range zd.Value.(*ZoneData).RR[t] {
+		for i, zr := range zd.RR[t] {
 			if r == zr {
-				zd.Value.(*ZoneData).RR[t] = append(zd.Value.(*ZoneData).RR[t][:i], zd.Value.(*ZoneData).RR[t][i+1:]...)
+				zd.RR[t] = append(zd.RR[t][:i], zd.RR[t][i+1:]...)
 				remove = true
 			}
 		}
@@ -191,33 +251,465 @@ func (z *Zone) Remove(r RR) error {
 func (z *Zone) Find(s string) *ZoneData {
 	z.mutex.RLock()
 	defer z.mutex.RUnlock()
-	zd := z.Radix.Find(toRadixName(s))
-	if zd == nil {
-		return nil
-	}
-	return zd.Value.(*ZoneData)
+	return z.names[toRadixName(s)]
 }
 
 // Predecessor searches the zone for a name shorter than s.
 func (z *Zone) Predecessor(s string) *ZoneData {
+	// A write lock is needed, not just read: if keys were appended
+	// since the last sort, ensureSorted must be able to sort z.sorted
+	// in place before it is searched.
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.ensureSorted()
+	key := toRadixName(s)
+	i := sort.SearchStrings(z.sorted, key)
+	if i == 0 {
+		return nil
+	}
+	return z.names[z.sorted[i-1]]
+}
+
+// Errors is a collection of errors encountered while (re)signing or
+// parsing a zone. Neither a signing run nor a parse stops on the first
+// error; each keeps going so that as much of the zone as possible ends
+// up signed or loaded.
+type Errors []error
+
+func (e Errors) Error() string {
+	if len(e) == 0 {
+		return "dns: no errors"
+	}
+	s := e[0].Error()
+	if len(e) > 1 {
+		s += fmt.Sprintf(" (and %d more)", len(e)-1)
+	}
+	return s
+}
+
+// walk traverses the zone in NSEC order (the order toRadixName preserves
+// in the sorted name index) and calls fn for every ZoneData node. Walking stops
+// as soon as fn returns false. walk briefly takes a write lock on z.mutex to
+// sort the name index if needed, then a read lock on z.mutex for the
+// duration of the traversal and a read lock on each ZoneData as it visits it,
+// so concurrent Find calls continue to work throughout a (possibly long) walk.
+func (z *Zone) walk(fn func(zd *ZoneData) bool) {
+	z.mutex.Lock()
+	z.ensureSorted()
+	z.mutex.Unlock()
+
 	z.mutex.RLock()
 	defer z.mutex.RUnlock()
-	zd := z.Radix.Predecessor(toRadixName(s))
-	if zd == nil {
+	for _, key := range z.sorted {
+		zd := z.names[key]
+		zd.mutex.RLock()
+		ok := fn(zd)
+		zd.mutex.RUnlock()
+		if !ok {
+			return
+		}
+	}
+}
+
+// Walk traverses the zone in canonical NSEC order (the order toRadixName
+// preserves) and calls fn for every ZoneData node, stopping early if fn
+// returns false. It is the building block used internally by Sign to
+// construct the NSEC chain, and is also useful to AXFR responders that
+// need to stream records in canonical order and to callers implementing
+// incremental consistency checks.
+func (z *Zone) Walk(fn func(zd *ZoneData) bool) {
+	z.walk(fn)
+}
+
+// WalkType behaves like Walk, but only invokes fn for nodes that hold
+// at least one RR of type rrtype, passing the node's name and its RRs
+// of that type.
+func (z *Zone) WalkType(rrtype uint16, fn func(name string, rrs []RR) bool) {
+	z.walk(func(zd *ZoneData) bool {
+		rrs, ok := zd.RR[rrtype]
+		if !ok || len(rrs) == 0 {
+			return true
+		}
+		return fn(zd.Name, rrs)
+	})
+}
+
+// Delegations returns every node in the zone where NonAuth is true,
+// i.e. the NS nodes that mark a delegation to a child zone.
+func (z *Zone) Delegations() []*ZoneData {
+	var d []*ZoneData
+	z.walk(func(zd *ZoneData) bool {
+		if zd.NonAuth {
+			d = append(d, zd)
+		}
+		return true
+	})
+	return d
+}
+
+// Glue returns the glue A/AAAA records required for the delegation
+// named delegation: the address records held by nodes underneath
+// delegation that currently sit beneath a delegation (NS) point. This is
+// resolved against the zone's current contents rather than cached on
+// insert, so it is correct regardless of whether the address records or
+// the delegating NS were inserted first.
+func (z *Zone) Glue(delegation string) []RR {
+	delegation = Fqdn(delegation)
+	var rrs []RR
+	z.walk(func(zd *ZoneData) bool {
+		if !IsSubDomain(delegation, zd.Name) || !z.underDelegation(zd.Name) {
+			return true
+		}
+		rrs = append(rrs, zd.RR[TypeA]...)
+		rrs = append(rrs, zd.RR[TypeAAAA]...)
+		return true
+	})
+	return rrs
+}
+
+// ownerNames returns the owner names of the zone in NSEC order.
+func (z *Zone) ownerNames() []string {
+	var s []string
+	z.walk(func(zd *ZoneData) bool {
+		s = append(s, zd.Name)
+		return true
+	})
+	return s
+}
+
+// splitKeys splits keys into the KSKs (SEP bit set) that sign the DNSKEY
+// RRset, and the ZSKs that sign the rest of the zone.
+func splitKeys(keys []*RR_DNSKEY) (ksks, zsks []*RR_DNSKEY) {
+	for _, k := range keys {
+		if k.Flags&1 == 1 { // SEP bit
+			ksks = append(ksks, k)
+		} else {
+			zsks = append(zsks, k)
+		}
+	}
+	return ksks, zsks
+}
+
+// signingKeys derives a PrivateKey for every key in keys, keyed by key tag.
+func signingKeys(keys []*RR_DNSKEY) (map[uint16]PrivateKey, error) {
+	privs := make(map[uint16]PrivateKey)
+	for _, k := range keys {
+		priv, err := k.NewPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		privs[k.KeyTag()] = priv
+	}
+	return privs, nil
+}
+
+// appendErrs flattens err into errs, expanding it if it is itself an
+// Errors value, and is a no-op if err is nil.
+func appendErrs(errs Errors, err error) Errors {
+	if err == nil {
+		return errs
+	}
+	if me, ok := err.(Errors); ok {
+		return append(errs, me...)
+	}
+	return append(errs, err)
+}
+
+// needsResign reports whether an existing RRSIG should be kept (false) or
+// replaced (true) because its expiration is within config.Refresh of now.
+func needsResign(sig *RR_RRSIG, config *SignatureConfig) bool {
+	expire := time.Unix(int64(sig.Expiration), 0)
+	return expire.Sub(time.Now()) <= config.Refresh
+}
+
+// sign creates a new RRSIG for rrset, owned by name, covering rrtype and
+// signed with key, honoring config's Validity, Jitter and InceptionOffset.
+func sign(name string, rrtype uint16, rrset []RR, key *RR_DNSKEY, priv PrivateKey, config *SignatureConfig) (*RR_RRSIG, error) {
+	jitter := time.Duration(0)
+	if config.Jitter > 0 {
+		jitter = time.Duration(rand.Int63n(int64(2*config.Jitter))) - config.Jitter
+	}
+	now := time.Now()
+	incep := now.Add(-config.InceptionOffset)
+	expire := now.Add(config.Validity).Add(jitter)
+
+	sig := new(RR_RRSIG)
+	sig.Hdr = RR_Header{Name: name, Rrtype: TypeRRSIG, Class: ClassINET, Ttl: rrset[0].Header().Ttl}
+	sig.TypeCovered = rrtype
+	sig.Algorithm = key.Algorithm
+	sig.Labels = uint8(CountLabel(name))
+	sig.OrigTtl = rrset[0].Header().Ttl
+	sig.Expiration = uint32(expire.Unix())
+	sig.Inception = uint32(incep.Unix())
+	sig.KeyTag = key.KeyTag()
+	sig.SignerName = key.Hdr.Name
+
+	if err := sig.Sign(priv, rrset); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// signZoneData (re)signs every RRset held by zd with the ZSKs, skipping
+// RRsets whose existing RRSIG is not yet due for a refresh.
+func signZoneData(zd *ZoneData, zsks []*RR_DNSKEY, privs map[uint16]PrivateKey, config *SignatureConfig) error {
+	zd.mutex.Lock()
+	defer zd.mutex.Unlock()
+
+	var errs Errors
+	for rrtype, rrset := range zd.RR {
+		if rrtype == TypeRRSIG {
+			continue
+		}
+		if len(rrset) == 0 {
+			// Remove leaves an emptied, non-nil slice under its type
+			// key rather than deleting it outright; nothing to sign.
+			continue
+		}
+		stale := true
+		for _, sig := range zd.Signatures[rrtype] {
+			if !needsResign(sig, config) {
+				stale = false
+				break
+			}
+		}
+		if !stale {
+			continue
+		}
+		zd.Signatures[rrtype] = nil
+		for _, k := range zsks {
+			sig, err := sign(zd.Name, rrtype, rrset, k, privs[k.KeyTag()], config)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			zd.Signatures[rrtype] = append(zd.Signatures[rrtype], sig)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// nsecBitmap returns the sorted set of RR types present at zd, plus
+// NSEC and RRSIG, suitable for an NSEC type bitmap.
+func nsecBitmap(zd *ZoneData) []uint16 {
+	types := make([]uint16, 0, len(zd.RR)+2)
+	for t, rrs := range zd.RR {
+		if len(rrs) == 0 || t == TypeNSEC || t == TypeRRSIG {
+			continue
+		}
+		types = append(types, t)
+	}
+	types = append(types, TypeNSEC, TypeRRSIG)
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// signDNSKEY (re)signs the DNSKEY RRset at the zone apex with ksks.
+func (z *Zone) signDNSKEY(ksks []*RR_DNSKEY, privs map[uint16]PrivateKey, config *SignatureConfig) error {
+	apex := z.Find(z.Origin)
+	if apex == nil {
+		return &Error{Err: "zone has no apex data", Name: z.Origin}
+	}
+	dnskeys := apex.RR[TypeDNSKEY]
+	if len(dnskeys) == 0 {
 		return nil
 	}
-	return zd.Value.(*ZoneData)
+	apex.mutex.Lock()
+	defer apex.mutex.Unlock()
+	apex.Signatures[TypeDNSKEY] = nil
+	var errs Errors
+	for _, k := range ksks {
+		sig, err := sign(z.Origin, TypeDNSKEY, dnskeys, k, privs[k.KeyTag()], config)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		apex.Signatures[TypeDNSKEY] = append(apex.Signatures[TypeDNSKEY], sig)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// signZSKs fans signing of every RRset in the zone out over a worker
+// pool of GOMAXPROCS goroutines, each worker locking only the ZoneData
+// it is currently signing and signing its RRsets with zsks. Errors are
+// collected by a dedicated goroutine so a slow consumer can never make
+// a worker block on a full error channel.
+func (z *Zone) signZSKs(zsks []*RR_DNSKEY, privs map[uint16]PrivateKey, config *SignatureConfig) error {
+	workers := runtime.GOMAXPROCS(0)
+	if workers < 1 {
+		workers = 1
+	}
+	work := make(chan *ZoneData)
+	errCh := make(chan error)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for zd := range work {
+				if err := signZoneData(zd, zsks, privs, config); err != nil {
+					errCh <- err
+				}
+			}
+		}()
+	}
+
+	var errs Errors
+	collected := make(chan struct{})
+	go func() {
+		for err := range errCh {
+			errs = appendErrs(errs, err)
+		}
+		close(collected)
+	}()
+
+	z.walk(func(zd *ZoneData) bool {
+		work <- zd
+		return true
+	})
+	close(work)
+	wg.Wait()
+	close(errCh)
+	<-collected
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
-// Sign (re)signes the zone z. It adds keys to the zone (if not already there)
-// and signs the keys with the KSKs and the rest of the zone with the ZSKs. For
-// authenticated denial of existence NSEC is used.
-// If config is nil DefaultSignatureConfig is used.
+// Sign (re)signs the zone z. It signs the DNSKEY RRset at the apex with
+// the KSKs in keys, builds the NSEC chain for authenticated denial of
+// existence, then signs the whole zone (including the freshly built
+// NSEC RRsets) with the ZSKs. If config is nil DefaultSignatureConfig is
+// used. Signing the zone fans out over a worker pool of GOMAXPROCS
+// goroutines, each worker locking only the ZoneData it is currently
+// signing.
 func (z *Zone) Sign(keys []*RR_DNSKEY, config *SignatureConfig) error {
 	if config == nil {
 		config = DefaultSignatureConfig
 	}
-	// concurrently walk the zone and sign the rrsets
+	ksks, zsks := splitKeys(keys)
+	privs, err := signingKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	var errs Errors
+	errs = appendErrs(errs, z.signDNSKEY(ksks, privs, config))
+	errs = appendErrs(errs, z.signNSEC(config))
+	errs = appendErrs(errs, z.signZSKs(zsks, privs, config))
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// signNSEC builds the NSEC chain for the zone: every owner name gets an
+// unsigned NSEC record whose NextDomain points to the next name in NSEC
+// order (wrapping around to z.Origin) and whose type bitmap reflects
+// the RR types present at that name. The NSEC RRsets themselves are
+// signed later, along with the rest of the zone, by signZSKs.
+func (z *Zone) signNSEC(config *SignatureConfig) error {
+	names := z.ownerNames()
+	if len(names) == 0 {
+		return nil
+	}
+	for i, name := range names {
+		next := names[(i+1)%len(names)]
+		zd := z.Find(name)
+		if zd == nil {
+			continue
+		}
+		nsec := &RR_NSEC{
+			Hdr:        RR_Header{Name: name, Rrtype: TypeNSEC, Class: ClassINET, Ttl: DefaultTtl},
+			NextDomain: next,
+			TypeBitMap: nsecBitmap(zd),
+		}
+		if err := z.Insert(nsec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// SignNSEC3 behaves like Sign, but builds an NSEC3 chain instead of an
+// NSEC chain, hashing owner names with param's salt, iteration count and
+// hash algorithm. The resulting NSEC3 records are stored under their
+// hashed owner names, and param itself is inserted as the zone's
+// NSEC3PARAM record.
+func (z *Zone) SignNSEC3(keys []*RR_DNSKEY, config *SignatureConfig, param *RR_NSEC3PARAM) error {
+	if config == nil {
+		config = DefaultSignatureConfig
+	}
+	ksks, zsks := splitKeys(keys)
+	privs, err := signingKeys(keys)
+	if err != nil {
+		return err
+	}
+
+	var errs Errors
+	errs = appendErrs(errs, z.signDNSKEY(ksks, privs, config))
+
+	param.Hdr = RR_Header{Name: z.Origin, Rrtype: TypeNSEC3PARAM, Class: ClassINET, Ttl: DefaultTtl}
+	errs = appendErrs(errs, z.Insert(param))
+
+	errs = appendErrs(errs, z.signNSEC3(param))
+	errs = appendErrs(errs, z.signZSKs(zsks, privs, config))
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// signNSEC3 builds the NSEC3 chain for the zone: every owner name is
+// hashed with param's salt, iteration count and hash algorithm, the
+// hashes are sorted into the NSEC3 hash-ring order, and each hashed
+// owner gets an unsigned NSEC3 record whose NextDomain points to the
+// next hash in that ring (wrapping around). The NSEC3 RRsets themselves
+// are signed later, along with the rest of the zone, by signZSKs.
+func (z *Zone) signNSEC3(param *RR_NSEC3PARAM) error {
+	names := z.ownerNames()
+	if len(names) == 0 {
+		return nil
+	}
+
+	type hashedName struct {
+		hash string
+		zd   *ZoneData
+	}
+	hashes := make([]hashedName, 0, len(names))
+	for _, name := range names {
+		zd := z.Find(name)
+		if zd == nil {
+			continue
+		}
+		hash := HashName(name, param.Hash, int(param.Iterations), param.Salt)
+		hashes = append(hashes, hashedName{hash, zd})
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].hash < hashes[j].hash })
+
+	for i, h := range hashes {
+		next := hashes[(i+1)%len(hashes)].hash
+		nsec3 := &RR_NSEC3{
+			Hdr:        RR_Header{Name: h.hash + "." + z.Origin, Rrtype: TypeNSEC3, Class: ClassINET, Ttl: DefaultTtl},
+			Hash:       param.Hash,
+			Flags:      param.Flags,
+			Iterations: param.Iterations,
+			SaltLength: param.SaltLength,
+			Salt:       param.Salt,
+			NextDomain: next,
+			TypeBitMap: nsecBitmap(h.zd),
+		}
+		if err := z.Insert(nsec3); err != nil {
+			return err
+		}
+	}
 	return nil
 }
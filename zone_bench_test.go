@@ -0,0 +1,44 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// benchZone builds a zone with n A records under example.org., used to
+// compare the map+sorted-slice backing store against the radix tree it
+// replaced.
+func benchZone(n int) (*Zone, []string) {
+	z := NewZone("example.org.")
+	names := make([]string, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("host%d.example.org.", i)
+		names[i] = name
+		rr := &RR_A{Hdr: RR_Header{Name: name, Rrtype: TypeA, Class: ClassINET, Ttl: 3600}}
+		z.Insert(rr)
+	}
+	return z, names
+}
+
+func BenchmarkInsert1M(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		z := NewZone("example.org.")
+		b.StartTimer()
+		for j := 0; j < 1000000; j++ {
+			name := fmt.Sprintf("host%d.example.org.", j)
+			rr := &RR_A{Hdr: RR_Header{Name: name, Rrtype: TypeA, Class: ClassINET, Ttl: 3600}}
+			z.Insert(rr)
+		}
+	}
+}
+
+func BenchmarkFindRandom(b *testing.B) {
+	b.StopTimer()
+	z, names := benchZone(1000000)
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		z.Find(names[rand.Intn(len(names))])
+	}
+}
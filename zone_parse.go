@@ -0,0 +1,102 @@
+package dns
+
+// Streaming RFC 1035 master file parsing straight into a Zone.
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// ProgressFunc is called periodically while a zone is being parsed so
+// callers loading large zones can report status. rrCount is the number
+// of RRs inserted so far, bytesRead the number of bytes consumed from
+// the underlying reader.
+type ProgressFunc func(rrCount int, bytesRead int64)
+
+// Parse reads a RFC 1035 master file from r and inserts the RRs it
+// contains into z as they are parsed, without buffering the whole zone
+// in memory. Parse understands $INCLUDE, $ORIGIN, $TTL and $GENERATE,
+// via the same tokenizer used by ParseZone. progress, if non-nil, is
+// called after every RR is inserted.
+//
+// Parse is safe to call concurrently with Zone.Find; each insert takes
+// z's mutex just like a direct call to Zone.Insert would.
+func (z *Zone) Parse(r io.Reader, progress ProgressFunc) error {
+	return z.parse(r, "", progress)
+}
+
+// ParseFile behaves like Parse, but reads from the named file and uses
+// it for the file part of any parse errors, mirroring the file-argument
+// pattern used by ReadPrivateKey.
+func (z *Zone) ParseFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return z.parse(f, file, nil)
+}
+
+// ParseFileProgress behaves like ParseFile, but additionally reports
+// progress as the file is consumed.
+func (z *Zone) ParseFileProgress(file string, progress ProgressFunc) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return z.parse(f, file, progress)
+}
+
+func (z *Zone) parse(r io.Reader, file string, progress ProgressFunc) error {
+	var bytesRead int64
+	cr := &countingReader{r: r}
+	tokens := ParseZone(cr, z.Origin, file)
+
+	var errs Errors
+	rrCount := 0
+	for t := range tokens {
+		bytesRead = cr.bytesRead()
+		if t.Error != nil {
+			errs = append(errs, t.Error)
+			continue
+		}
+		if t.RR == nil {
+			continue // blank line or comment-only token
+		}
+		if err := z.Insert(t.RR); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rrCount++
+		if progress != nil {
+			progress(rrCount, bytesRead)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// countingReader wraps an io.Reader and tracks how many bytes have been
+// read from it, so Parse can report progress without requiring the
+// underlying tokenizer to expose its own position. ParseZone runs its
+// tokenizer in its own goroutine, reading ahead of the tokens it has
+// already handed to parse's consumer loop, so n is accessed with
+// sync/atomic rather than shared as a plain field.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func (c *countingReader) bytesRead() int64 {
+	return atomic.LoadInt64(&c.n)
+}
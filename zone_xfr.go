@@ -0,0 +1,293 @@
+package dns
+
+// AXFR/IXFR zone transfer helpers that apply the transferred RRs
+// straight into a Zone.
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// TsigConfig holds the key material needed to sign and verify TSIG
+// protected transfer messages.
+type TsigConfig struct {
+	Name      string // Key name, as used in the TSIG RR
+	Secret    string // Base64 encoded shared secret
+	Algorithm string // e.g. HmacMD5
+	Fudge     int64  // Allowed clock skew, in seconds
+}
+
+// AXFR performs a full zone transfer of z.Origin from server (host:port,
+// TCP) and replaces the zone's contents with the result. Existing data
+// under z.Origin is cleared only once the transfer has fully succeeded,
+// so a failed or rejected transfer never leaves the zone half-updated.
+func (z *Zone) AXFR(server string, tsig *TsigConfig) error {
+	m := new(Msg)
+	m.SetQuestion(z.Origin, TypeAXFR)
+	if tsig != nil {
+		m.SetTsig(tsig.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+
+	rrs, err := xfrReceive(server, m, tsig)
+	if err != nil {
+		return err
+	}
+	_, err = z.applyAxfr(rrs)
+	return err
+}
+
+// IXFR performs an incremental zone transfer of z.Origin starting from
+// serial, applying each delta atomically under z.mutex. It returns the
+// number of RRs applied (added or removed). The transfer is rejected if
+// the server's starting (delete-section) SOA serial does not match serial.
+func (z *Zone) IXFR(server string, serial uint32, tsig *TsigConfig) (int, error) {
+	m := new(Msg)
+	m.SetIxfr(z.Origin, serial)
+	if tsig != nil {
+		m.SetTsig(tsig.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+
+	rrs, err := xfrReceive(server, m, tsig)
+	if err != nil {
+		return 0, err
+	}
+	if len(rrs) < 2 {
+		return 0, &Error{Err: "ixfr: truncated response", Name: z.Origin}
+	}
+
+	// rrs[0] is the server's current (newest) SOA, not the serial we
+	// asked for; the serial we requested is echoed back as rrs[1], the
+	// leading SOA of the first delete section.
+	if _, ok := rrs[0].(*RR_SOA); !ok {
+		return 0, &Error{Err: "ixfr: response does not start with SOA", Name: z.Origin}
+	}
+	deleteOpen, ok := rrs[1].(*RR_SOA)
+	if !ok {
+		return 0, &Error{Err: "ixfr: response does not start with a delete-section SOA", Name: z.Origin}
+	}
+	if deleteOpen.Serial != serial {
+		return 0, &Error{Err: "ixfr: server serial does not match requested serial", Name: z.Origin}
+	}
+
+	return z.applyIxfr(rrs)
+}
+
+// applyAxfr replaces the zone wholesale with rrs.
+func (z *Zone) applyAxfr(rrs []RR) (int, error) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	// insertLocked only fails on out-of-zone data; reject that before
+	// clearing anything, so a bad transfer leaves the zone untouched.
+	for _, rr := range rrs {
+		if !IsSubDomain(z.Origin, rr.Header().Name) {
+			return 0, &Error{Err: "out of zone data", Name: rr.Header().Name}
+		}
+	}
+	z.names = make(map[string]*ZoneData)
+	z.sorted = nil
+	z.Wildcard = 0
+	for _, rr := range rrs {
+		if err := z.insertLocked(rr); err != nil {
+			return 0, err
+		}
+	}
+	return len(rrs), nil
+}
+
+// applyIxfr walks the alternating remove/add SOA-framed sections of an
+// IXFR response and applies each delta to z. Each section's leading SOA
+// is itself part of the delta (the old SOA is removed, the new one
+// added), so the zone never ends up holding a stale SOA alongside the
+// current one. The whole response is applied under a single hold of
+// z.mutex, so a concurrent Find can never observe a half-applied delta.
+func (z *Zone) applyIxfr(rrs []RR) (int, error) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	applied := 0
+	i := 1 // rrs[0] is the leading (newest) SOA, already checked by the caller
+	for i < len(rrs) {
+		deleteOpen, ok := rrs[i].(*RR_SOA)
+		if !ok {
+			return applied, &Error{Err: "ixfr: expected delete-section SOA", Name: z.Origin}
+		}
+		i++
+		removed := []RR{deleteOpen}
+		for i < len(rrs) {
+			if _, ok := rrs[i].(*RR_SOA); ok {
+				break
+			}
+			removed = append(removed, rrs[i])
+			i++
+		}
+		if i >= len(rrs) {
+			return applied, &Error{Err: "ixfr: truncated add section", Name: z.Origin}
+		}
+		addOpen, ok := rrs[i].(*RR_SOA)
+		if !ok {
+			return applied, &Error{Err: "ixfr: expected add-section SOA", Name: z.Origin}
+		}
+		i++
+		added := []RR{addOpen}
+		for i < len(rrs) {
+			if _, ok := rrs[i].(*RR_SOA); ok {
+				break
+			}
+			added = append(added, rrs[i])
+			i++
+		}
+
+		// The whole delta is applied while z.mutex is held, so it is
+		// never visible half-removed-half-added.
+		for _, rr := range removed {
+			// removeLocked matches by identity, so find the stored RR
+			// equal to the transferred one and remove that instead.
+			zd, ok := z.names[toRadixName(rr.Header().Name)]
+			if !ok {
+				continue
+			}
+			var stored RR
+			for _, zr := range zd.RR[rr.Header().Rrtype] {
+				if zr.String() == rr.String() {
+					stored = zr
+					break
+				}
+			}
+			if stored == nil {
+				continue
+			}
+			if err := z.removeLocked(stored); err != nil {
+				return applied, err
+			}
+			applied++
+		}
+		for _, rr := range added {
+			if err := z.insertLocked(rr); err != nil {
+				return applied, err
+			}
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// xfrReceive signs (if tsig is given) and sends the already built query
+// m over a new TCP connection to server, then reads back the full
+// (possibly multi-message) transfer stream, flattening it into a single
+// RR slice in wire order. Every response message is validated against
+// tsig, when given; per RFC 2845, the first reply is verified against
+// the request's own MAC, and every later message in the stream chains
+// off the previous message's MAC with timersOnly set.
+//
+// Both AXFR (a single bookend pair of identical SOAs) and IXFR (a
+// leading SOA followed by any number of delete/add delta sections) are
+// parsed the same way: every RR after the leading SOA is read in order,
+// and each SOA marker switches between the delete-section and
+// add-section roles, starting with delete. The stream is complete once a SOA
+// expected in the delete-section role instead carries the same serial
+// as the very first RR - that can only happen once there are no more
+// deltas to apply, since a genuine delete section always opens with an
+// older serial than the zone's current one. That closing SOA carries no
+// data of its own and is not included in the returned RRs.
+func xfrReceive(server string, m *Msg, tsig *TsigConfig) ([]RR, error) {
+	conn, err := net.Dial("tcp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var out []byte
+	reqMAC := ""
+	if tsig != nil {
+		out, reqMAC, err = TsigGenerate(m, tsig.Secret, "", false)
+	} else {
+		out, err = m.Pack()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := writeTcpMsg(conn, out); err != nil {
+		return nil, err
+	}
+
+	var rrs []RR
+	var firstSerial uint32
+	haveFirst := false
+	expectDeleteOpen := true
+	prevMAC := reqMAC
+	msgCount := 0
+	for {
+		in, err := readTcpMsg(conn)
+		if err != nil {
+			return nil, err
+		}
+		reply := new(Msg)
+		if err := reply.Unpack(in); err != nil {
+			return nil, err
+		}
+		if tsig != nil {
+			t := reply.IsTsig()
+			if t == nil {
+				return nil, &Error{Err: "xfr: response is not TSIG signed", Name: m.Question[0].Name}
+			}
+			timersOnly := msgCount > 0
+			if err := TsigVerify(in, tsig.Secret, prevMAC, timersOnly); err != nil {
+				return nil, err
+			}
+			prevMAC = t.MAC
+		}
+		msgCount++
+
+		for _, rr := range reply.Answer {
+			soa, isSOA := rr.(*RR_SOA)
+			if isSOA && !haveFirst {
+				firstSerial = soa.Serial
+				haveFirst = true
+				rrs = append(rrs, rr)
+				continue
+			}
+			if isSOA {
+				if expectDeleteOpen && soa.Serial == firstSerial {
+					return rrs, nil
+				}
+				expectDeleteOpen = !expectDeleteOpen
+			}
+			rrs = append(rrs, rr)
+		}
+	}
+}
+
+func writeTcpMsg(conn net.Conn, m []byte) error {
+	l := make([]byte, 2)
+	binary.BigEndian.PutUint16(l, uint16(len(m)))
+	if _, err := conn.Write(l); err != nil {
+		return err
+	}
+	_, err := conn.Write(m)
+	return err
+}
+
+func readTcpMsg(conn net.Conn) ([]byte, error) {
+	l := make([]byte, 2)
+	if _, err := readFull(conn, l); err != nil {
+		return nil, err
+	}
+	m := make([]byte, binary.BigEndian.Uint16(l))
+	if _, err := readFull(conn, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func readFull(conn net.Conn, buf []byte) (int, error) {
+	n := 0
+	for n < len(buf) {
+		i, err := conn.Read(buf[n:])
+		if err != nil {
+			return n, err
+		}
+		n += i
+	}
+	return n, nil
+}